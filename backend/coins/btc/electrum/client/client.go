@@ -18,12 +18,17 @@ package client
 
 import (
 	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
@@ -45,22 +50,112 @@ const (
 type ElectrumClient struct {
 	rpc *jsonrpc.RPCClient
 
-	scriptHashNotificationCallbacks     map[string][]func(string)
+	scriptHashNotificationCallbacks     map[string][]func(status string, err error)
 	scriptHashNotificationCallbacksLock sync.RWMutex
 
 	serverVersion *ServerVersion
 
+	// headersCompressionAdvertised caches whether the connected server advertised support for
+	// compressed blockchain.block.headers replies in server.features(), checked once on connect.
+	headersCompressionAdvertised bool
+
+	transactionGetBatchSupport       batchSupport
+	getMerkleBatchSupport            batchSupport
+	scriptHashGetHistoryBatchSupport batchSupport
+
+	// transactionGetCoalescer, getMerkleCoalescer and scriptHashGetHistoryCoalescer let
+	// TransactionGet/GetMerkle/ScriptHashGetHistory be called one at a time from scattered call
+	// sites while still batching calls that land within coalesceWindow of each other into a single
+	// *Batch round trip.
+	transactionGetCoalescer       *requestCoalescer
+	getMerkleCoalescer            *requestCoalescer
+	scriptHashGetHistoryCoalescer *requestCoalescer
+
 	close bool
 	log   *logrus.Entry
 }
 
+// coalesceWindow is how long TransactionGet/GetMerkle/ScriptHashGetHistory wait for more calls to
+// arrive before dispatching the calls collected so far as a single batch request.
+const coalesceWindow = 20 * time.Millisecond
+
+// batchSupport caches whether the connected server is known to support a particular batch RPC
+// method, so repeated calls don't have to re-probe it. nil means not yet probed.
+type batchSupport struct {
+	mu        sync.Mutex
+	supported *bool
+}
+
+func (b *batchSupport) get() *bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.supported
+}
+
+func (b *batchSupport) set(supported bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.supported = &supported
+}
+
+// pendingCallback is one caller's success/cleanup pair waiting on a coalesced batch request. arg
+// carries any extra per-request data a flush needs besides the id (e.g. GetMerkle's height).
+type pendingCallback struct {
+	arg     interface{}
+	success func(interface{})
+	cleanup func(error)
+}
+
+// requestCoalescer batches single-item requests keyed by id into periodic flushes: the first add()
+// in a new batch arms a coalesceWindow timer, and every add() that lands before it fires joins the
+// same batch. This lets call sites keep invoking a single-item method one at a time while still
+// getting the benefit of a *Batch round trip when several calls happen close together.
+type requestCoalescer struct {
+	// flush is called once per batch with every collected id and its callbacks.
+	flush func(ids []string, callbacks map[string][]pendingCallback)
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending map[string][]pendingCallback
+}
+
+func (c *requestCoalescer) add(id string, arg interface{}, success func(interface{}), cleanup func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = map[string][]pendingCallback{}
+	}
+	c.pending[id] = append(c.pending[id], pendingCallback{arg: arg, success: success, cleanup: cleanup})
+	if c.timer == nil {
+		c.timer = time.AfterFunc(coalesceWindow, c.flushPending)
+	}
+}
+
+func (c *requestCoalescer) flushPending() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.timer = nil
+	c.mu.Unlock()
+	ids := make([]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	c.flush(ids, pending)
+}
+
 // NewElectrumClient creates a new Electrum client.
 func NewElectrumClient(rpcClient *jsonrpc.RPCClient, log *logrus.Entry) *ElectrumClient {
 	electrumClient := &ElectrumClient{
 		rpc:                             rpcClient,
-		scriptHashNotificationCallbacks: map[string][]func(string){},
+		scriptHashNotificationCallbacks: map[string][]func(status string, err error){},
 		log:                             log.WithField("group", "client"),
 	}
+	electrumClient.transactionGetCoalescer = &requestCoalescer{flush: electrumClient.flushTransactionGetBatch}
+	electrumClient.getMerkleCoalescer = &requestCoalescer{flush: electrumClient.flushGetMerkleBatch}
+	electrumClient.scriptHashGetHistoryCoalescer = &requestCoalescer{
+		flush: electrumClient.flushScriptHashGetHistoryBatch,
+	}
 	// Install a callback for the scripthash notifications, which directs the response to callbacks
 	// installed by ScriptHashSubscribe().
 	rpcClient.SubscribeNotifications(
@@ -83,7 +178,8 @@ func NewElectrumClient(rpcClient *jsonrpc.RPCClient, log *logrus.Entry) *Electru
 			callbacks := electrumClient.scriptHashNotificationCallbacks[scriptHash]
 			electrumClient.scriptHashNotificationCallbacksLock.RUnlock()
 			for _, callback := range callbacks {
-				callback(status)
+				electrumClient.verifyAndForwardScriptHashStatus(
+					blockchain.ScriptHashHex(scriptHash), status, callback)
 			}
 		},
 	)
@@ -97,6 +193,9 @@ func NewElectrumClient(rpcClient *jsonrpc.RPCClient, log *logrus.Entry) *Electru
 		}
 		electrumClient.serverVersion = version
 		log.WithField("server-version", version).Debug("electrumx server version")
+		if features, err := electrumClient.ServerFeatures(); err == nil {
+			electrumClient.headersCompressionAdvertised = features.HeadersCompression
+		}
 		return nil
 	})
 	rpcClient.RegisterHeartbeat("server.ping")
@@ -172,6 +271,9 @@ func (client *ElectrumClient) ServerVersion() (*ServerVersion, error) {
 // ServerFeatures is returned by ServerFeatures().
 type ServerFeatures struct {
 	GenesisHash string `json:"genesis_hash"`
+	// HeadersCompression is a herald-style extension advertising support for compressed
+	// blockchain.block.headers replies.
+	HeadersCompression bool `json:"headers_compression"`
 }
 
 // ServerFeatures does the server.features() RPC call.
@@ -182,6 +284,111 @@ func (client *ElectrumClient) ServerFeatures() (*ServerFeatures, error) {
 	return response, err
 }
 
+// ServerPeer is a single peer Electrum server, as advertised by the currently connected server via
+// server.peers.subscribe.
+// https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-methods.rst#serverpeerssubscribe
+type ServerPeer struct {
+	Host     string
+	Hostname string
+	// TCPPort is the plaintext TCP port, or 0 if not advertised.
+	TCPPort int
+	// SSLPort is the TLS port, or 0 if not advertised.
+	SSLPort int
+	// ProtocolVersion is the highest protocol version advertised by the peer, or nil if the peer
+	// did not advertise one.
+	ProtocolVersion *semver.SemVer
+	// Pruning is the pruning limit in blocks, or 0 if the peer does not prune.
+	Pruning int
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It parses one [ip, hostname, features]
+// tuple as returned by server.peers.subscribe.
+func (peer *ServerPeer) UnmarshalJSON(b []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(b, &tuple); err != nil {
+		return errp.WithStack(err)
+	}
+	if len(tuple) != 3 {
+		return errp.Newf("unexpected server.peers.subscribe entry (expected 3 elements, got %d)", len(tuple))
+	}
+	if err := json.Unmarshal(tuple[0], &peer.Host); err != nil {
+		return errp.WithStack(err)
+	}
+	if err := json.Unmarshal(tuple[1], &peer.Hostname); err != nil {
+		return errp.WithStack(err)
+	}
+	var features []string
+	if err := json.Unmarshal(tuple[2], &features); err != nil {
+		return errp.WithStack(err)
+	}
+	for _, feature := range features {
+		if feature == "" {
+			continue
+		}
+		tag, value := feature[:1], feature[1:]
+		switch tag {
+		case "s":
+			peer.SSLPort = parsePeerPort(value, 50002)
+		case "t":
+			peer.TCPPort = parsePeerPort(value, 50001)
+		case "p":
+			if pruning, err := strconv.Atoi(value); err == nil {
+				peer.Pruning = pruning
+			}
+		case "v":
+			if protocolVersion, err := parsePeerProtocolVersion(value); err == nil {
+				peer.ProtocolVersion = protocolVersion
+			}
+		}
+	}
+	return nil
+}
+
+// parsePeerPort parses a port number advertised in a peer feature string (e.g. "s" or "t"),
+// falling back to defaultPort if value is empty or not a valid port (both allowed by the
+// protocol, which lets a peer advertise a feature without specifying its standard port).
+func parsePeerPort(value string, defaultPort int) int {
+	if value == "" {
+		return defaultPort
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultPort
+	}
+	return port
+}
+
+// parsePeerProtocolVersion parses the value of a peer's "v" feature, which is either major.minor
+// or major.minor.patch.
+func parsePeerProtocolVersion(value string) (*semver.SemVer, error) {
+	if v, err := semver.NewSemVerFromString(value); err == nil {
+		return v, nil
+	}
+	return semver.NewSemVerFromString(value + ".0")
+}
+
+// ServerPeersSubscribe does the server.peers.subscribe() RPC call, returning the list of peer
+// Electrum servers advertised by the currently connected server.
+// https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-methods.rst#serverpeerssubscribe
+func (client *ElectrumClient) ServerPeersSubscribe(
+	success func([]*ServerPeer),
+	cleanup func(error),
+) {
+	client.rpc.Method(
+		func(responseBytes []byte) error {
+			var peers []*ServerPeer
+			if err := json.Unmarshal(responseBytes, &peers); err != nil {
+				return errp.WithStack(err)
+			}
+			success(peers)
+			return nil
+		},
+		func() func(error) {
+			return cleanup
+		},
+		"server.peers.subscribe")
+}
+
 // Balance is returned by ScriptHashGetBalance().
 type Balance struct {
 	Confirmed   int64 `json:"confirmed"`
@@ -210,12 +417,26 @@ func (client *ElectrumClient) ScriptHashGetBalance(
 		scriptHashHex)
 }
 
-// ScriptHashGetHistory does the blockchain.scripthash.get_history() RPC call.
+// ScriptHashGetHistory does the blockchain.scripthash.get_history() RPC call, coalescing calls
+// issued within coalesceWindow of each other into a single ScriptHashGetHistoryBatch round trip.
 // https://github.com/kyuupichan/electrumx/blob/159db3f8e70b2b2cbb8e8cd01d1e9df3fe83828f/docs/PROTOCOL.rst#blockchainscripthashget_history
 func (client *ElectrumClient) ScriptHashGetHistory(
 	scriptHashHex blockchain.ScriptHashHex,
 	success func(blockchain.TxHistory),
 	cleanup func(error),
+) {
+	client.scriptHashGetHistoryCoalescer.add(string(scriptHashHex), nil,
+		func(v interface{}) { success(v.(blockchain.TxHistory)) },
+		cleanup)
+}
+
+// scriptHashGetHistory does the blockchain.scripthash.get_history() RPC call directly, bypassing
+// the coalescer. Used internally where a caller already has a batch of ids in hand (the
+// ScriptHashGetHistoryBatch fallback) or needs an immediate answer (verifyAndForwardScriptHashStatus).
+func (client *ElectrumClient) scriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory),
+	cleanup func(error),
 ) {
 	client.rpc.Method(
 		func(responseBytes []byte) error {
@@ -234,16 +455,154 @@ func (client *ElectrumClient) ScriptHashGetHistory(
 		string(scriptHashHex))
 }
 
+// flushScriptHashGetHistoryBatch is the scriptHashGetHistoryCoalescer flush: it resolves every
+// coalesced scripthash via ScriptHashGetHistoryBatch.
+func (client *ElectrumClient) flushScriptHashGetHistoryBatch(ids []string, callbacks map[string][]pendingCallback) {
+	scriptHashHexes := make([]blockchain.ScriptHashHex, len(ids))
+	for i, id := range ids {
+		scriptHashHexes[i] = blockchain.ScriptHashHex(id)
+	}
+	client.ScriptHashGetHistoryBatch(scriptHashHexes, func(result map[blockchain.ScriptHashHex]blockchain.TxHistory) {
+		for _, id := range ids {
+			txs, ok := result[blockchain.ScriptHashHex(id)]
+			for _, cb := range callbacks[id] {
+				if ok {
+					cb.success(txs)
+				} else {
+					cb.cleanup(errp.Newf("missing scripthash history for %s in batch result", id))
+				}
+			}
+		}
+	}, func(err error) {
+		for _, cbs := range callbacks {
+			for _, cb := range cbs {
+				cb.cleanup(err)
+			}
+		}
+	})
+}
+
+// ScriptHashGetHistoryBatch fetches histories for multiple scripthashes, trying
+// blockchain.scripthash.get_history_batch first and falling back (cached for the connection's
+// lifetime) to one blockchain.scripthash.get_history call per scripthash if the server rejects it.
+//
+// success is invoked at most once with the histories keyed by scripthash; cleanup is invoked
+// instead if any history could not be retrieved.
+func (client *ElectrumClient) ScriptHashGetHistoryBatch(
+	scriptHashHexes []blockchain.ScriptHashHex,
+	success func(map[blockchain.ScriptHashHex]blockchain.TxHistory),
+	cleanup func(error),
+) {
+	if len(scriptHashHexes) == 0 {
+		success(map[blockchain.ScriptHashHex]blockchain.TxHistory{})
+		return
+	}
+	if supported := client.scriptHashGetHistoryBatchSupport.get(); supported != nil && !*supported {
+		client.scriptHashGetHistoryBatchFallback(scriptHashHexes, success, cleanup)
+		return
+	}
+	client.scriptHashGetHistoryBatchServerSide(scriptHashHexes, success, cleanup)
+}
+
+func (client *ElectrumClient) scriptHashGetHistoryBatchServerSide(
+	scriptHashHexes []blockchain.ScriptHashHex,
+	success func(map[blockchain.ScriptHashHex]blockchain.TxHistory),
+	cleanup func(error),
+) {
+	ids := make([]string, len(scriptHashHexes))
+	for i, scriptHashHex := range scriptHashHexes {
+		ids[i] = string(scriptHashHex)
+	}
+	client.rpc.Method(
+		func(responseBytes []byte) error {
+			response := map[string]blockchain.TxHistory{}
+			if err := json.Unmarshal(responseBytes, &response); err != nil {
+				return errp.WithStack(err)
+			}
+			client.scriptHashGetHistoryBatchSupport.set(true)
+			result := make(map[blockchain.ScriptHashHex]blockchain.TxHistory, len(scriptHashHexes))
+			for _, scriptHashHex := range scriptHashHexes {
+				txs, ok := response[string(scriptHashHex)]
+				if !ok {
+					return errp.Newf(
+						"missing scripthash %s in get_history_batch response", scriptHashHex)
+				}
+				result[scriptHashHex] = txs
+			}
+			success(result)
+			return nil
+		},
+		func() func(error) {
+			return func(err error) {
+				if err == nil {
+					return
+				}
+				client.log.WithError(err).Info(
+					"blockchain.scripthash.get_history_batch failed, falling back to per-scripthash fetches")
+				client.scriptHashGetHistoryBatchSupport.set(false)
+				client.scriptHashGetHistoryBatchFallback(scriptHashHexes, success, cleanup)
+			}
+		},
+		"blockchain.scripthash.get_history_batch",
+		ids)
+}
+
+// scriptHashGetHistoryBatchFallback fetches each history with its own
+// blockchain.scripthash.get_history call, all dispatched up front so they are pipelined over the
+// connection instead of paying a full round trip per scripthash.
+func (client *ElectrumClient) scriptHashGetHistoryBatchFallback(
+	scriptHashHexes []blockchain.ScriptHashHex,
+	success func(map[blockchain.ScriptHashHex]blockchain.TxHistory),
+	cleanup func(error),
+) {
+	var mu sync.Mutex
+	result := make(map[blockchain.ScriptHashHex]blockchain.TxHistory, len(scriptHashHexes))
+	failed := false
+	pending := len(scriptHashHexes)
+	for _, scriptHashHex := range scriptHashHexes {
+		scriptHashHex := scriptHashHex
+		client.scriptHashGetHistory(scriptHashHex, func(txs blockchain.TxHistory) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failed {
+				return
+			}
+			result[scriptHashHex] = txs
+			pending--
+			if pending == 0 {
+				success(result)
+			}
+		}, func(err error) {
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if failed {
+				return
+			}
+			failed = true
+			cleanup(err)
+		})
+	}
+}
+
 // ScriptHashSubscribe does the blockchain.scripthash.subscribe() RPC call.
+//
+// success is called every time the status changes, including for the initial reply. err is
+// ErrScriptHashStatusMismatch if the status still does not match a freshly fetched history after
+// one refetch (see verifyAndForwardScriptHashStatus), or another non-nil error if that history
+// could not be fetched - status is still the value reported by the server, but callers should treat a non-nil err as a sign of a misbehaving or malicious server
+// and should drop the connection, same as ErrInvalidMerkleProof.
 // https://github.com/kyuupichan/electrumx/blob/159db3f8e70b2b2cbb8e8cd01d1e9df3fe83828f/docs/PROTOCOL.rst#blockchainscripthashsubscribe
 func (client *ElectrumClient) ScriptHashSubscribe(
 	setupAndTeardown func() func(error),
 	scriptHashHex blockchain.ScriptHashHex,
-	success func(string),
+	success func(status string, err error),
 ) {
 	client.scriptHashNotificationCallbacksLock.Lock()
 	if _, ok := client.scriptHashNotificationCallbacks[string(scriptHashHex)]; !ok {
-		client.scriptHashNotificationCallbacks[string(scriptHashHex)] = []func(string){}
+		client.scriptHashNotificationCallbacks[string(scriptHashHex)] = []func(string, error){}
 	}
 	client.scriptHashNotificationCallbacks[string(scriptHashHex)] = append(
 		client.scriptHashNotificationCallbacks[string(scriptHashHex)],
@@ -258,9 +617,9 @@ func (client *ElectrumClient) ScriptHashSubscribe(
 				return errp.WithStack(err)
 			}
 			if response == nil {
-				success("")
+				success("", nil)
 			} else {
-				success(*response)
+				client.verifyAndForwardScriptHashStatus(scriptHashHex, *response, success)
 			}
 			return nil
 		},
@@ -269,6 +628,83 @@ func (client *ElectrumClient) ScriptHashSubscribe(
 		string(scriptHashHex))
 }
 
+// ComputeScriptHashStatus computes the scripthash status hash as specified by the Electrum
+// protocol: the hex-encoded SHA256 of the concatenation of "{tx_hash}:{height}:" for every history
+// entry, in the order returned by the server, or the empty string if the history is empty.
+// https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-basics.rst#status
+func ComputeScriptHashStatus(txs blockchain.TxHistory) string {
+	if len(txs) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	for _, tx := range txs {
+		fmt.Fprintf(&buf, "%s:%d:", tx.TXHash.Hash().String(), tx.Height)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrScriptHashStatusMismatch is passed to a ScriptHashSubscribe success callback when the locally
+// recomputed scripthash status (see ComputeScriptHashStatus) still does not match the status
+// reported by the server after refetching its history once. Mirrors ErrInvalidMerkleProof: it lets
+// callers distinguish a misbehaving or malicious server from an ordinary connection error.
+var ErrScriptHashStatusMismatch = errp.New("scripthash status does not match recomputed history hash")
+
+// verifyAndForwardScriptHashStatus fetches the history for scriptHashHex and checks that its
+// locally recomputed status matches status as reported by the server, before forwarding status to
+// success. On mismatch, the history is refetched once; if the mismatch persists,
+// ErrScriptHashStatusMismatch is forwarded alongside status instead of nil.
+func (client *ElectrumClient) verifyAndForwardScriptHashStatus(
+	scriptHashHex blockchain.ScriptHashHex,
+	status string,
+	success func(status string, err error),
+) {
+	verifyAndForwardScriptHashStatus(scriptHashHex, status, success, client.scriptHashGetHistory, client.log)
+}
+
+// verifyAndForwardScriptHashStatus is the testable core of ElectrumClient.verifyAndForwardScriptHashStatus:
+// fetch is called to obtain the history to verify status against, defaulting to
+// ElectrumClient.scriptHashGetHistory in production and stubbed out in tests.
+func verifyAndForwardScriptHashStatus(
+	scriptHashHex blockchain.ScriptHashHex,
+	status string,
+	success func(status string, err error),
+	fetch func(blockchain.ScriptHashHex, func(blockchain.TxHistory), func(error)),
+	log *logrus.Entry,
+) {
+	if status == "" {
+		success(status, nil)
+		return
+	}
+	fetch(scriptHashHex, func(txs blockchain.TxHistory) {
+		if ComputeScriptHashStatus(txs) == status {
+			success(status, nil)
+			return
+		}
+		log.WithField("scripthash", scriptHashHex).
+			Warning("scripthash status does not match recomputed history hash, refreshing")
+		fetch(scriptHashHex, func(txs blockchain.TxHistory) {
+			if ComputeScriptHashStatus(txs) != status {
+				log.WithField("scripthash", scriptHashHex).
+					Error("scripthash status mismatch persists after refresh, server may be misbehaving")
+				success(status, errp.WithStack(ErrScriptHashStatusMismatch))
+				return
+			}
+			success(status, nil)
+		}, func(err error) {
+			if err == nil {
+				return
+			}
+			success(status, errp.Wrap(err, "failed to refresh scripthash history"))
+		})
+	}, func(err error) {
+		if err == nil {
+			return
+		}
+		success(status, errp.Wrap(err, "failed to fetch scripthash history to verify status"))
+	})
+}
+
 func parseTX(rawTXHex string) (*wire.MsgTx, error) {
 	rawTX, err := hex.DecodeString(rawTXHex)
 	if err != nil {
@@ -281,12 +717,26 @@ func parseTX(rawTXHex string) (*wire.MsgTx, error) {
 	return tx, nil
 }
 
-// TransactionGet downloads a transaction.
+// TransactionGet downloads a transaction, coalescing calls issued within coalesceWindow of each
+// other into a single TransactionGetBatch round trip.
 // See https://github.com/kyuupichan/electrumx/blob/159db3f8e70b2b2cbb8e8cd01d1e9df3fe83828f/docs/PROTOCOL.rst#blockchaintransactionget
 func (client *ElectrumClient) TransactionGet(
 	txHash chainhash.Hash,
 	success func(*wire.MsgTx),
 	cleanup func(error),
+) {
+	client.transactionGetCoalescer.add(txHash.String(), nil,
+		func(v interface{}) { success(v.(*wire.MsgTx)) },
+		cleanup)
+}
+
+// transactionGet does the blockchain.transaction.get() RPC call directly, bypassing the
+// coalescer. Used internally where a caller already has a batch of hashes in hand (the
+// TransactionGetBatch fallback).
+func (client *ElectrumClient) transactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx),
+	cleanup func(error),
 ) {
 	client.rpc.Method(
 		func(responseBytes []byte) error {
@@ -308,6 +758,165 @@ func (client *ElectrumClient) TransactionGet(
 		txHash.String())
 }
 
+// flushTransactionGetBatch is the transactionGetCoalescer flush: it resolves every coalesced hash
+// via TransactionGetBatch.
+func (client *ElectrumClient) flushTransactionGetBatch(ids []string, callbacks map[string][]pendingCallback) {
+	txHashes := make([]chainhash.Hash, 0, len(ids))
+	for _, id := range ids {
+		if h, err := chainhash.NewHashFromStr(id); err == nil {
+			txHashes = append(txHashes, *h)
+		}
+	}
+	client.TransactionGetBatch(txHashes, func(result map[chainhash.Hash]*wire.MsgTx) {
+		for _, id := range ids {
+			h, _ := chainhash.NewHashFromStr(id)
+			tx, ok := result[*h]
+			for _, cb := range callbacks[id] {
+				if ok {
+					cb.success(tx)
+				} else {
+					cb.cleanup(errp.Newf("missing transaction %s in batch result", id))
+				}
+			}
+		}
+	}, func(err error) {
+		for _, cbs := range callbacks {
+			for _, cb := range cbs {
+				cb.cleanup(err)
+			}
+		}
+	})
+}
+
+// transactionGetBatchEntry unmarshals one entry of a blockchain.transaction.get_batch reply, which
+// is a tuple of the raw transaction hex followed by merkle proof info that TransactionGetBatch
+// does not need.
+type transactionGetBatchEntry struct {
+	rawTXHex string
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (entry *transactionGetBatchEntry) UnmarshalJSON(b []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(b, &tuple); err != nil {
+		return errp.WithStack(err)
+	}
+	if len(tuple) == 0 {
+		return errp.New("unexpected empty blockchain.transaction.get_batch entry")
+	}
+	return json.Unmarshal(tuple[0], &entry.rawTXHex)
+}
+
+// TransactionGetBatch downloads multiple transactions in as few round trips as possible, trying
+// blockchain.transaction.get_batch first and falling back (cached for the connection's lifetime)
+// to one blockchain.transaction.get call per transaction if the server rejects it.
+//
+// success is invoked at most once with the transactions keyed by hash; cleanup is invoked instead
+// if any transaction could not be retrieved.
+func (client *ElectrumClient) TransactionGetBatch(
+	txHashes []chainhash.Hash,
+	success func(map[chainhash.Hash]*wire.MsgTx),
+	cleanup func(error),
+) {
+	if len(txHashes) == 0 {
+		success(map[chainhash.Hash]*wire.MsgTx{})
+		return
+	}
+	if supported := client.transactionGetBatchSupport.get(); supported != nil && !*supported {
+		client.transactionGetBatchFallback(txHashes, success, cleanup)
+		return
+	}
+	client.transactionGetBatchServerSide(txHashes, success, cleanup)
+}
+
+func (client *ElectrumClient) transactionGetBatchServerSide(
+	txHashes []chainhash.Hash,
+	success func(map[chainhash.Hash]*wire.MsgTx),
+	cleanup func(error),
+) {
+	ids := make([]string, len(txHashes))
+	for i, txHash := range txHashes {
+		ids[i] = txHash.String()
+	}
+	client.rpc.Method(
+		func(responseBytes []byte) error {
+			response := map[string]transactionGetBatchEntry{}
+			if err := json.Unmarshal(responseBytes, &response); err != nil {
+				return errp.WithStack(err)
+			}
+			client.transactionGetBatchSupport.set(true)
+			result := make(map[chainhash.Hash]*wire.MsgTx, len(txHashes))
+			for _, txHash := range txHashes {
+				entry, ok := response[txHash.String()]
+				if !ok {
+					return errp.Newf("missing transaction %s in get_batch response", txHash)
+				}
+				tx, err := parseTX(entry.rawTXHex)
+				if err != nil {
+					return err
+				}
+				result[txHash] = tx
+			}
+			success(result)
+			return nil
+		},
+		func() func(error) {
+			return func(err error) {
+				if err == nil {
+					return
+				}
+				// The server most likely doesn't implement blockchain.transaction.get_batch -
+				// fall back to fetching transactions one by one, now and for future calls.
+				client.log.WithError(err).Info(
+					"blockchain.transaction.get_batch failed, falling back to per-transaction fetches")
+				client.transactionGetBatchSupport.set(false)
+				client.transactionGetBatchFallback(txHashes, success, cleanup)
+			}
+		},
+		"blockchain.transaction.get_batch",
+		ids)
+}
+
+// transactionGetBatchFallback fetches each transaction with its own blockchain.transaction.get
+// call. The calls are all dispatched up front instead of waiting for each response in turn, so
+// they are pipelined over the connection instead of paying a full round trip per transaction.
+func (client *ElectrumClient) transactionGetBatchFallback(
+	txHashes []chainhash.Hash,
+	success func(map[chainhash.Hash]*wire.MsgTx),
+	cleanup func(error),
+) {
+	var mu sync.Mutex
+	result := make(map[chainhash.Hash]*wire.MsgTx, len(txHashes))
+	failed := false
+	pending := len(txHashes)
+	for _, txHash := range txHashes {
+		txHash := txHash
+		client.transactionGet(txHash, func(tx *wire.MsgTx) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failed {
+				return
+			}
+			result[txHash] = tx
+			pending--
+			if pending == 0 {
+				success(result)
+			}
+		}, func(err error) {
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if failed {
+				return
+			}
+			failed = true
+			cleanup(err)
+		})
+	}
+}
+
 type electrumHeader struct {
 	// Provided by v1.4
 	BlockHeight int `json:"block_height"`
@@ -475,6 +1084,143 @@ func (client *ElectrumClient) EstimateFee(
 		number)
 }
 
+// FeeHistogramEntry is one bucket of a mempool fee histogram: feeRate is in satoshis/vbyte, and
+// vsize is the virtual size, in bytes, of the mempool transactions in this bucket alone (paying at
+// least feeRate, but less than the previous, higher bucket's fee rate).
+type FeeHistogramEntry [2]float64
+
+// FeeRate returns the bucket's fee rate, in satoshis/vbyte.
+func (entry FeeHistogramEntry) FeeRate() float64 {
+	return entry[0]
+}
+
+// VSize returns the bucket's virtual size, in bytes.
+func (entry FeeHistogramEntry) VSize() float64 {
+	return entry[1]
+}
+
+// MempoolGetFeeHistogram does the mempool.get_fee_histogram() RPC call. The entries are ordered
+// from the highest fee rate down to the lowest.
+// https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-methods.rst#mempoolget_fee_histogram
+func (client *ElectrumClient) MempoolGetFeeHistogram(
+	success func([]FeeHistogramEntry),
+	cleanup func(error),
+) {
+	client.rpc.Method(
+		func(responseBytes []byte) error {
+			var histogram []FeeHistogramEntry
+			if err := json.Unmarshal(responseBytes, &histogram); err != nil {
+				return errp.WithStack(err)
+			}
+			success(histogram)
+			return nil
+		},
+		func() func(error) {
+			return cleanup
+		},
+		"mempool.get_fee_histogram")
+}
+
+// vsizePerBlock is the nominal block size, in virtual bytes, used to translate a confirmation
+// target in blocks into a cumulative mempool vsize threshold when walking a fee histogram.
+const vsizePerBlock = 1_000_000
+
+// feeRateForTarget walks histogram, which is ordered from the highest fee rate to the lowest,
+// accumulating vsize until it covers targetBlocks worth of transactions, and returns the fee rate
+// at which that threshold is crossed.
+func feeRateForTarget(histogram []FeeHistogramEntry, targetBlocks int) (float64, bool) {
+	threshold := float64(targetBlocks) * vsizePerBlock
+	var cumulative float64
+	for _, entry := range histogram {
+		cumulative += entry.VSize()
+		if cumulative >= threshold {
+			return entry.FeeRate(), true
+		}
+	}
+	return 0, false
+}
+
+// FeeTargets estimates a fee rate for each of the given confirmation targets (in blocks). It
+// combines the mempool fee histogram (for a fine-grained, purely mempool-based estimate) with
+// EstimateFee (for targets the histogram can't satisfy, or if the server has no histogram
+// available), so the wallet UI can render a slider for many targets without issuing a round trip
+// per target.
+//
+// success is invoked once with an entry for every target that could be estimated; a target is
+// silently omitted if neither the histogram nor EstimateFee could produce a fee rate for it. If no
+// target could be estimated at all and an EstimateFee call failed, cleanup is invoked with the last
+// such error instead; if none failed, success is invoked with an empty map.
+func (client *ElectrumClient) FeeTargets(
+	targets []int,
+	success func(map[int]btcutil.Amount),
+	cleanup func(error),
+) {
+	client.MempoolGetFeeHistogram(func(histogram []FeeHistogramEntry) {
+		client.feeTargetsFromHistogram(targets, histogram, success, cleanup)
+	}, func(err error) {
+		if err == nil {
+			return
+		}
+		// No histogram available - fall back to EstimateFee for every target.
+		client.feeTargetsFromHistogram(targets, nil, success, cleanup)
+	})
+}
+
+func (client *ElectrumClient) feeTargetsFromHistogram(
+	targets []int,
+	histogram []FeeHistogramEntry,
+	success func(map[int]btcutil.Amount),
+	cleanup func(error),
+) {
+	if len(targets) == 0 {
+		success(map[int]btcutil.Amount{})
+		return
+	}
+	var mu sync.Mutex
+	result := make(map[int]btcutil.Amount, len(targets))
+	pending := len(targets)
+	var lastErr error
+	done := func() {
+		pending--
+		if pending > 0 {
+			return
+		}
+		if len(result) == 0 && lastErr != nil {
+			cleanup(lastErr)
+			return
+		}
+		success(result)
+	}
+	for _, target := range targets {
+		if feeRate, ok := feeRateForTarget(histogram, target); ok {
+			// Convert satoshis/vbyte to BTC/kvB, the unit EstimateFee reports.
+			if amount, err := btcutil.NewAmount(feeRate * 1000 / btcutil.SatoshiPerBitcoin); err == nil {
+				mu.Lock()
+				result[target] = amount
+				done()
+				mu.Unlock()
+				continue
+			}
+		}
+		target := target
+		client.EstimateFee(target, func(amount *btcutil.Amount) {
+			mu.Lock()
+			defer mu.Unlock()
+			if amount != nil {
+				result[target] = *amount
+			}
+			done()
+		}, func(err error) {
+			client.log.WithError(err).WithField("target", target).
+				Warning("failed to estimate fee for target")
+			mu.Lock()
+			defer mu.Unlock()
+			lastErr = err
+			done()
+		})
+	}
+}
+
 func parseHeaders(reader io.Reader) ([]*wire.BlockHeader, error) {
 	headers := []*wire.BlockHeader{}
 	for {
@@ -491,8 +1237,16 @@ func parseHeaders(reader io.Reader) ([]*wire.BlockHeader, error) {
 	return headers, nil
 }
 
+// blockHeaderSize is the wire size in bytes of a single Bitcoin block header.
+const blockHeaderSize = 80
+
 // Headers does the blockchain.block.headers() RPC call. See
 // https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-methods.rst#blockchainblockheaders
+//
+// Some servers (e.g. herald) reply with a zlib-compressed, base64-encoded header blob instead of
+// plain hex; this is detected via "compressed": true and transparently decompressed. A mismatch
+// against the capability advertised in server.features() is logged, since it means the server is
+// misreporting itself.
 func (client *ElectrumClient) Headers(
 	startHeight int, count int,
 	success func(headers []*wire.BlockHeader, max int),
@@ -500,14 +1254,29 @@ func (client *ElectrumClient) Headers(
 	client.rpc.Method(
 		func(responseBytes []byte) error {
 			var response struct {
-				Hex   string `json:"hex"`
-				Count int    `json:"count"`
-				Max   int    `json:"max"`
+				Hex        string `json:"hex"`
+				Count      int    `json:"count"`
+				Max        int    `json:"max"`
+				Compressed bool   `json:"compressed"`
 			}
 			if err := json.Unmarshal(responseBytes, &response); err != nil {
 				return errp.WithStack(err)
 			}
-			headers, err := parseHeaders(hex.NewDecoder(strings.NewReader(response.Hex)))
+			if response.Compressed != client.headersCompressionAdvertised {
+				client.log.WithField("compressed", response.Compressed).
+					Warning("blockchain.block.headers compression does not match server.features()")
+			}
+			var headerReader io.Reader
+			if response.Compressed {
+				reader, err := decompressHeaders(response.Hex, response.Count)
+				if err != nil {
+					return errp.Wrap(err, "Failed to decompress headers")
+				}
+				headerReader = reader
+			} else {
+				headerReader = hex.NewDecoder(strings.NewReader(response.Hex))
+			}
+			headers, err := parseHeaders(headerReader)
 			if err != nil {
 				return err
 			}
@@ -527,12 +1296,57 @@ func (client *ElectrumClient) Headers(
 		startHeight, count)
 }
 
-// GetMerkle does the blockchain.transaction.get_merkle() RPC call. See
+// decompressHeaders decodes the zlib-compressed, base64-encoded header blob some servers send
+// instead of plain hex. The inflated output is capped at count headers' worth of bytes, since
+// count is known up front and the stream comes from an untrusted server - without a cap, a
+// malicious server could zip-bomb a tiny reply into an unbounded allocation.
+func decompressHeaders(base64Hex string, count int) (io.Reader, error) {
+	compressed, err := base64.StdEncoding.DecodeString(base64Hex)
+	if err != nil {
+		return nil, errp.Wrap(err, "Failed to base64-decode compressed headers")
+	}
+	zlibReader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, errp.Wrap(err, "Failed to open zlib reader")
+	}
+	defer func() { _ = zlibReader.Close() }()
+	maxSize := int64(count) * blockHeaderSize
+	var inflated bytes.Buffer
+	written, err := io.CopyN(&inflated, zlibReader, maxSize)
+	if err != nil && err != io.EOF {
+		return nil, errp.Wrap(err, "Failed to inflate compressed headers")
+	}
+	if written == maxSize {
+		if n, _ := zlibReader.Read(make([]byte, 1)); n > 0 {
+			return nil, errp.Newf("compressed headers exceed the expected %d bytes", maxSize)
+		}
+	}
+	return &inflated, nil
+}
+
+// GetMerkle does the blockchain.transaction.get_merkle() RPC call, coalescing calls issued within
+// coalesceWindow of each other into a single GetMerkleBatch round trip. See
 // https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-methods.rst#blockchaintransactionget_merkle
 func (client *ElectrumClient) GetMerkle(
 	txHash chainhash.Hash, height int,
 	success func(merkle []blockchain.TXHash, pos int),
 	cleanup func(error),
+) {
+	client.getMerkleCoalescer.add(txHash.String(), height,
+		func(v interface{}) {
+			merkle := v.(Merkle)
+			success(merkle.Branch, merkle.Pos)
+		},
+		cleanup)
+}
+
+// getMerkle does the blockchain.transaction.get_merkle() RPC call directly, bypassing the
+// coalescer. Used internally where a caller already has a batch of hashes in hand (the
+// GetMerkleBatch fallback).
+func (client *ElectrumClient) getMerkle(
+	txHash chainhash.Hash, height int,
+	success func(merkle []blockchain.TXHash, pos int),
+	cleanup func(error),
 ) {
 	client.rpc.Method(
 		func(responseBytes []byte) error {
@@ -557,6 +1371,224 @@ func (client *ElectrumClient) GetMerkle(
 		txHash.String(), height)
 }
 
+// flushGetMerkleBatch is the getMerkleCoalescer flush: it resolves every coalesced hash via
+// GetMerkleBatch. Each pendingCallback's arg carries the height passed to GetMerkle for that hash.
+func (client *ElectrumClient) flushGetMerkleBatch(ids []string, callbacks map[string][]pendingCallback) {
+	txHeights := make(map[chainhash.Hash]int, len(ids))
+	hashByID := make(map[string]chainhash.Hash, len(ids))
+	for _, id := range ids {
+		h, err := chainhash.NewHashFromStr(id)
+		if err != nil {
+			continue
+		}
+		hashByID[id] = *h
+		if cbs := callbacks[id]; len(cbs) > 0 {
+			txHeights[*h] = cbs[0].arg.(int)
+		}
+	}
+	client.GetMerkleBatch(txHeights, func(result map[chainhash.Hash]Merkle) {
+		for _, id := range ids {
+			merkle, ok := result[hashByID[id]]
+			for _, cb := range callbacks[id] {
+				if ok {
+					cb.success(merkle)
+				} else {
+					cb.cleanup(errp.Newf("missing merkle proof for %s in batch result", id))
+				}
+			}
+		}
+	}, func(err error) {
+		for _, cbs := range callbacks {
+			for _, cb := range cbs {
+				cb.cleanup(err)
+			}
+		}
+	})
+}
+
+// Merkle is one result entry of GetMerkleBatch: the branch hashes and position needed to
+// recompute a transaction's confirming block's merkle root, as returned by GetMerkle/
+// blockchain.transaction.get_merkle.
+type Merkle struct {
+	Branch []blockchain.TXHash
+	Pos    int
+}
+
+// getMerkleBatchEntry unmarshals one entry of a blockchain.transaction.get_merkle_batch reply.
+type getMerkleBatchEntry struct {
+	Merkle      []blockchain.TXHash `json:"merkle"`
+	Pos         int                 `json:"pos"`
+	BlockHeight int                 `json:"block_height"`
+}
+
+// GetMerkleBatch fetches merkle proofs for multiple transactions, trying
+// blockchain.transaction.get_merkle_batch first and falling back (cached for the connection's
+// lifetime) to one blockchain.transaction.get_merkle call per transaction if the server rejects
+// it.
+//
+// success is invoked at most once with the results keyed by hash; cleanup is invoked instead if
+// any proof could not be retrieved.
+func (client *ElectrumClient) GetMerkleBatch(
+	txHeights map[chainhash.Hash]int,
+	success func(map[chainhash.Hash]Merkle),
+	cleanup func(error),
+) {
+	if len(txHeights) == 0 {
+		success(map[chainhash.Hash]Merkle{})
+		return
+	}
+	if supported := client.getMerkleBatchSupport.get(); supported != nil && !*supported {
+		client.getMerkleBatchFallback(txHeights, success, cleanup)
+		return
+	}
+	client.getMerkleBatchServerSide(txHeights, success, cleanup)
+}
+
+func (client *ElectrumClient) getMerkleBatchServerSide(
+	txHeights map[chainhash.Hash]int,
+	success func(map[chainhash.Hash]Merkle),
+	cleanup func(error),
+) {
+	ids := make([]string, 0, len(txHeights))
+	for txHash := range txHeights {
+		ids = append(ids, txHash.String())
+	}
+	client.rpc.Method(
+		func(responseBytes []byte) error {
+			response := map[string]getMerkleBatchEntry{}
+			if err := json.Unmarshal(responseBytes, &response); err != nil {
+				return errp.WithStack(err)
+			}
+			client.getMerkleBatchSupport.set(true)
+			result := make(map[chainhash.Hash]Merkle, len(txHeights))
+			for txHash, height := range txHeights {
+				entry, ok := response[txHash.String()]
+				if !ok {
+					return errp.Newf("missing transaction %s in get_merkle_batch response", txHash)
+				}
+				if entry.BlockHeight != height {
+					return errp.Newf("height should be %d, but got %d", height, entry.BlockHeight)
+				}
+				result[txHash] = Merkle{Branch: entry.Merkle, Pos: entry.Pos}
+			}
+			success(result)
+			return nil
+		},
+		func() func(error) {
+			return func(err error) {
+				if err == nil {
+					return
+				}
+				client.log.WithError(err).Info(
+					"blockchain.transaction.get_merkle_batch failed, falling back to per-transaction fetches")
+				client.getMerkleBatchSupport.set(false)
+				client.getMerkleBatchFallback(txHeights, success, cleanup)
+			}
+		},
+		"blockchain.transaction.get_merkle_batch",
+		ids)
+}
+
+// getMerkleBatchFallback fetches each merkle proof with its own blockchain.transaction.get_merkle
+// call, all dispatched up front so they are pipelined over the connection instead of paying a full
+// round trip per transaction.
+func (client *ElectrumClient) getMerkleBatchFallback(
+	txHeights map[chainhash.Hash]int,
+	success func(map[chainhash.Hash]Merkle),
+	cleanup func(error),
+) {
+	var mu sync.Mutex
+	result := make(map[chainhash.Hash]Merkle, len(txHeights))
+	failed := false
+	pending := len(txHeights)
+	for txHash, height := range txHeights {
+		txHash, height := txHash, height
+		client.getMerkle(txHash, height, func(branch []blockchain.TXHash, pos int) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failed {
+				return
+			}
+			result[txHash] = Merkle{Branch: branch, Pos: pos}
+			pending--
+			if pending == 0 {
+				success(result)
+			}
+		}, func(err error) {
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if failed {
+				return
+			}
+			failed = true
+			cleanup(err)
+		})
+	}
+}
+
+// ErrInvalidMerkleProof is passed to the cleanup callback of TransactionGetVerified when the
+// merkle branch returned by the server does not recompute to the merkle root of the verified
+// header for the transaction's confirming block. Callers should treat this as a sign of a
+// misbehaving or malicious server and should drop the connection.
+var ErrInvalidMerkleProof = errp.New("merkle proof does not match the block header")
+
+// merkleRootFromProof recomputes the merkle root of the block containing txHash, given the merkle
+// branch and position returned by GetMerkle(). See
+// https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-methods.rst#blockchaintransactionget_merkle
+func merkleRootFromProof(txHash chainhash.Hash, merkle []blockchain.TXHash, pos int) chainhash.Hash {
+	current := txHash
+	for _, node := range merkle {
+		sibling := node.Hash()
+		var combined [chainhash.HashSize * 2]byte
+		if pos&1 == 0 {
+			copy(combined[:chainhash.HashSize], current[:])
+			copy(combined[chainhash.HashSize:], sibling[:])
+		} else {
+			copy(combined[:chainhash.HashSize], sibling[:])
+			copy(combined[chainhash.HashSize:], current[:])
+		}
+		current = chainhash.DoubleHashH(combined[:])
+		pos >>= 1
+	}
+	return current
+}
+
+// TransactionGetVerified downloads a transaction and verifies, via its SPV merkle proof, that it
+// is actually included in the block at the given height. headerByHeight must return a verified
+// header for that height, e.g. one obtained and persisted via HeadersSubscribe/Headers - it is not
+// fetched from the (untrusted) server being queried here.
+//
+// If the merkle proof does not match headerByHeight's merkle root, ErrInvalidMerkleProof is passed
+// to cleanup instead of the usual error, so that callers can distinguish a malicious/misbehaving
+// server from an ordinary connection or parsing error and blacklist it.
+func (client *ElectrumClient) TransactionGetVerified(
+	txHash chainhash.Hash,
+	height int,
+	headerByHeight func(height int) (*wire.BlockHeader, error),
+	success func(*wire.MsgTx),
+	cleanup func(error),
+) {
+	client.GetMerkle(
+		txHash, height,
+		func(merkle []blockchain.TXHash, pos int) {
+			header, err := headerByHeight(height)
+			if err != nil {
+				cleanup(errp.Wrap(err, "Failed to get verified header for merkle proof"))
+				return
+			}
+			if merkleRootFromProof(txHash, merkle, pos) != header.MerkleRoot {
+				cleanup(errp.WithStack(ErrInvalidMerkleProof))
+				return
+			}
+			client.TransactionGet(txHash, success, cleanup)
+		},
+		cleanup,
+	)
+}
+
 // Close closes the connection.
 func (client *ElectrumClient) Close() {
 	client.close = true
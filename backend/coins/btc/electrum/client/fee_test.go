@@ -0,0 +1,56 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeRateForTarget(t *testing.T) {
+	histogram := []FeeHistogramEntry{
+		{50, 1_000_000},
+		{20, 1_000_000},
+		{10, 500_000},
+	}
+	tests := []struct {
+		name         string
+		histogram    []FeeHistogramEntry
+		targetBlocks int
+		wantFeeRate  float64
+		wantOK       bool
+	}{
+		{"first bucket already covers target", histogram, 1, 50, true},
+		{"needs to cross into the second bucket", histogram, 2, 20, true},
+		{"needs every bucket and still falls short", histogram, 3, 0, false},
+		{"empty histogram never matches", nil, 1, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feeRate, ok := feeRateForTarget(test.histogram, test.targetBlocks)
+			require.Equal(t, test.wantOK, ok)
+			if ok {
+				require.Equal(t, test.wantFeeRate, feeRate)
+			}
+		})
+	}
+}
+
+func TestFeeHistogramEntryAccessors(t *testing.T) {
+	entry := FeeHistogramEntry{12.5, 987}
+	require.Equal(t, 12.5, entry.FeeRate())
+	require.Equal(t, float64(987), entry.VSize())
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compressAndEncode(t *testing.T, raw []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecompressHeaders(t *testing.T) {
+	raw := bytes.Repeat([]byte{0xab}, 2*blockHeaderSize)
+	encoded := compressAndEncode(t, raw)
+
+	reader, err := decompressHeaders(encoded, 2)
+	require.NoError(t, err)
+	inflated, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, raw, inflated)
+}
+
+// TestDecompressHeadersRejectsOversizedPayload ensures a server can't zip-bomb a reply: the
+// inflated payload must fit within count*blockHeaderSize bytes, even though the compressed
+// (on-the-wire) size is tiny.
+func TestDecompressHeadersRejectsOversizedPayload(t *testing.T) {
+	count := 2
+	raw := bytes.Repeat([]byte{0xcd}, count*blockHeaderSize+1)
+	encoded := compressAndEncode(t, raw)
+
+	_, err := decompressHeaders(encoded, count)
+	require.Error(t, err)
+}
+
+func TestDecompressHeadersRejectsInvalidBase64(t *testing.T) {
+	_, err := decompressHeaders("not-valid-base64!!!", 1)
+	require.Error(t, err)
+}
+
+func TestDecompressHeadersRejectsInvalidZlib(t *testing.T) {
+	_, err := decompressHeaders(base64.StdEncoding.EncodeToString([]byte("not zlib data")), 1)
+	require.Error(t, err)
+}
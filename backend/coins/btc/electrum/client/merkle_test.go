@@ -0,0 +1,71 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/stretchr/testify/require"
+)
+
+func hashFromByte(b byte) chainhash.Hash {
+	var h chainhash.Hash
+	h[0] = b
+	return h
+}
+
+func TestMerkleRootFromProofNoBranch(t *testing.T) {
+	txHash := hashFromByte(0x01)
+	require.Equal(t, txHash, merkleRootFromProof(txHash, nil, 0))
+}
+
+func TestMerkleRootFromProofOneSibling(t *testing.T) {
+	txHash := hashFromByte(0x01)
+	sibling := hashFromByte(0x02)
+	branch := []blockchain.TXHash{blockchain.TXHash(sibling)}
+
+	got := merkleRootFromProof(txHash, branch, 0)
+	want := chainhash.DoubleHashH(append(append([]byte{}, txHash[:]...), sibling[:]...))
+	require.Equal(t, want, got, "pos even: current goes on the left")
+
+	got = merkleRootFromProof(txHash, branch, 1)
+	want = chainhash.DoubleHashH(append(append([]byte{}, sibling[:]...), txHash[:]...))
+	require.Equal(t, want, got, "pos odd: current goes on the right")
+}
+
+func TestMerkleRootFromProofMultipleLevels(t *testing.T) {
+	txHash := hashFromByte(0x01)
+	branch := []blockchain.TXHash{
+		blockchain.TXHash(hashFromByte(0x02)),
+		blockchain.TXHash(hashFromByte(0x03)),
+	}
+	// pos = 0b10: bit 0 (level 0) is 0 -> current on the left; bit 1 (level 1) is 1 -> the
+	// level-0 result goes on the right.
+	got := merkleRootFromProof(txHash, branch, 2)
+
+	level0 := chainhash.DoubleHashH(append(append([]byte{}, txHash[:]...), branch[0].Hash().CloneBytes()...))
+	want := chainhash.DoubleHashH(append(branch[1].Hash().CloneBytes(), level0[:]...))
+	require.Equal(t, want, got)
+}
+
+func TestMerkleRootFromProofMismatchIsDetectable(t *testing.T) {
+	txHash := hashFromByte(0x01)
+	branch := []blockchain.TXHash{blockchain.TXHash(hashFromByte(0x02))}
+
+	got := merkleRootFromProof(txHash, branch, 0)
+	require.NotEqual(t, hashFromByte(0xff), got)
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeScriptHashStatusEmptyHistory(t *testing.T) {
+	require.Equal(t, "", ComputeScriptHashStatus(blockchain.TxHistory{}))
+}
+
+func TestComputeScriptHashStatusMatchesElectrumFormat(t *testing.T) {
+	txs := blockchain.TxHistory{
+		{TXHash: blockchain.TXHash(hashFromByte(0x01)), Height: 100},
+		{TXHash: blockchain.TXHash(hashFromByte(0x02)), Height: 200},
+	}
+	var buf []byte
+	for _, tx := range txs {
+		buf = append(buf, []byte(fmt.Sprintf("%s:%d:", tx.TXHash.Hash().String(), tx.Height))...)
+	}
+	sum := sha256.Sum256(buf)
+	require.Equal(t, hex.EncodeToString(sum[:]), ComputeScriptHashStatus(txs))
+}
+
+func TestComputeScriptHashStatusOrderMatters(t *testing.T) {
+	a := blockchain.TxHistory{
+		{TXHash: blockchain.TXHash(hashFromByte(0x01)), Height: 100},
+		{TXHash: blockchain.TXHash(hashFromByte(0x02)), Height: 200},
+	}
+	b := blockchain.TxHistory{
+		{TXHash: blockchain.TXHash(hashFromByte(0x02)), Height: 200},
+		{TXHash: blockchain.TXHash(hashFromByte(0x01)), Height: 100},
+	}
+	require.NotEqual(t, ComputeScriptHashStatus(a), ComputeScriptHashStatus(b))
+}
+
+func fetchHistory(txs blockchain.TxHistory) func(blockchain.ScriptHashHex, func(blockchain.TxHistory), func(error)) {
+	return func(_ blockchain.ScriptHashHex, success func(blockchain.TxHistory), _ func(error)) {
+		success(txs)
+	}
+}
+
+func TestVerifyAndForwardScriptHashStatusEmptyStatus(t *testing.T) {
+	called := false
+	verifyAndForwardScriptHashStatus("scripthash", "", func(status string, err error) {
+		called = true
+		require.Equal(t, "", status)
+		require.NoError(t, err)
+	}, fetchHistory(nil), logrus.NewEntry(logrus.New()))
+	require.True(t, called)
+}
+
+func TestVerifyAndForwardScriptHashStatusMatch(t *testing.T) {
+	txs := blockchain.TxHistory{{TXHash: blockchain.TXHash(hashFromByte(0x01)), Height: 100}}
+	status := ComputeScriptHashStatus(txs)
+
+	called := false
+	verifyAndForwardScriptHashStatus("scripthash", status, func(gotStatus string, err error) {
+		called = true
+		require.Equal(t, status, gotStatus)
+		require.NoError(t, err)
+	}, fetchHistory(txs), logrus.NewEntry(logrus.New()))
+	require.True(t, called)
+}
+
+func TestVerifyAndForwardScriptHashStatusMismatchRecoversOnRetry(t *testing.T) {
+	staleTxs := blockchain.TxHistory{{TXHash: blockchain.TXHash(hashFromByte(0x01)), Height: 100}}
+	freshTxs := blockchain.TxHistory{
+		{TXHash: blockchain.TXHash(hashFromByte(0x01)), Height: 100},
+		{TXHash: blockchain.TXHash(hashFromByte(0x02)), Height: 200},
+	}
+	status := ComputeScriptHashStatus(freshTxs)
+
+	calls := 0
+	fetch := func(_ blockchain.ScriptHashHex, success func(blockchain.TxHistory), _ func(error)) {
+		calls++
+		if calls == 1 {
+			success(staleTxs)
+			return
+		}
+		success(freshTxs)
+	}
+
+	called := false
+	verifyAndForwardScriptHashStatus("scripthash", status, func(gotStatus string, err error) {
+		called = true
+		require.Equal(t, status, gotStatus)
+		require.NoError(t, err)
+	}, fetch, logrus.NewEntry(logrus.New()))
+	require.True(t, called)
+	require.Equal(t, 2, calls, "should have retried once after the first mismatch")
+}
+
+func TestVerifyAndForwardScriptHashStatusPersistentMismatchSurfacesError(t *testing.T) {
+	txs := blockchain.TxHistory{{TXHash: blockchain.TXHash(hashFromByte(0x01)), Height: 100}}
+
+	called := false
+	verifyAndForwardScriptHashStatus("scripthash", "not-the-real-status", func(gotStatus string, err error) {
+		called = true
+		require.ErrorIs(t, err, ErrScriptHashStatusMismatch)
+	}, fetchHistory(txs), logrus.NewEntry(logrus.New()))
+	require.True(t, called)
+}
+
+func TestVerifyAndForwardScriptHashStatusFetchError(t *testing.T) {
+	fetch := func(_ blockchain.ScriptHashHex, _ func(blockchain.TxHistory), cleanup func(error)) {
+		cleanup(fmt.Errorf("connection lost"))
+	}
+
+	called := false
+	verifyAndForwardScriptHashStatus("scripthash", "some-status", func(gotStatus string, err error) {
+		called = true
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "connection lost")
+	}, fetch, logrus.NewEntry(logrus.New()))
+	require.True(t, called)
+}
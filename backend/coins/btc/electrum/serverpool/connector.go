@@ -0,0 +1,107 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverpool
+
+import (
+	"sync"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Connector keeps a live ElectrumClient connected to the healthiest server known to a Pool,
+// failing over to the next-best remaining server when the connection drops or is reported as
+// SPV-invalid.
+type Connector struct {
+	pool *Pool
+	// dial establishes a fresh ElectrumClient connection to server.
+	dial func(Server) (*client.ElectrumClient, error)
+
+	mu      sync.Mutex
+	current *client.ElectrumClient
+	server  Server
+}
+
+// NewConnector creates a Connector backed by pool. dial is called with the chosen server whenever
+// a (re)connect is needed; it is responsible for the actual socket/TLS setup.
+func NewConnector(pool *Pool, dial func(Server) (*client.ElectrumClient, error)) *Connector {
+	return &Connector{pool: pool, dial: dial}
+}
+
+// Connect connects to the best available server in the pool and returns its client. When a
+// connected client reports DISCONNECTED, or ReportInvalidProof is called, the connector dials the
+// next-best remaining server and keeps the new client internally; the client returned here is not
+// replaced or redirected by that failover.
+func (connector *Connector) Connect() (*client.ElectrumClient, error) {
+	_, err := connector.failover(map[Server]bool{})
+	return connector.currentClient(), err
+}
+
+func (connector *Connector) currentClient() *client.ElectrumClient {
+	connector.mu.Lock()
+	defer connector.mu.Unlock()
+	return connector.current
+}
+
+func (connector *Connector) connectTo(server Server) (*client.ElectrumClient, error) {
+	electrumClient, err := connector.dial(server)
+	if err != nil {
+		connector.pool.ReportDialFailure(server)
+		return nil, errp.Wrap(err, "failed to connect to electrum server")
+	}
+	connector.pool.ReportDialSuccess(server)
+	connector.mu.Lock()
+	connector.current = electrumClient
+	connector.server = server
+	connector.mu.Unlock()
+	electrumClient.RegisterOnConnectionStatusChangedEvent(func(status blockchain.Status) {
+		if status == blockchain.DISCONNECTED {
+			_, _ = connector.failover(map[Server]bool{server: true})
+		}
+	})
+	return electrumClient, nil
+}
+
+// ReportInvalidProof tells the connector that the currently connected server served an
+// SPV-invalid response (e.g. a bad merkle proof), scoring it down and triggering an immediate
+// failover to the next-best peer.
+func (connector *Connector) ReportInvalidProof() {
+	connector.mu.Lock()
+	server := connector.server
+	connector.mu.Unlock()
+	connector.pool.ReportInvalidProof(server)
+	_, _ = connector.failover(map[Server]bool{server: true})
+}
+
+// failover iterates through the pool, excluding any server already in excluded, until a
+// connection succeeds or every known server has been tried. Each attempt that fails is added to
+// excluded, so a server that keeps failing can never be retried within the same failover pass -
+// this bounds the loop to at most one attempt per known server and avoids cycling forever between
+// a small set of simultaneously-unreachable peers.
+func (connector *Connector) failover(excluded map[Server]bool) (*client.ElectrumClient, error) {
+	for attempts, max := 0, connector.pool.Len()+1; attempts < max; attempts++ {
+		server, ok := connector.pool.Next(excluded)
+		if !ok {
+			return nil, errp.New("no electrum servers available in the pool")
+		}
+		electrumClient, err := connector.connectTo(server)
+		if err == nil {
+			return electrumClient, nil
+		}
+		excluded[server] = true
+	}
+	return nil, errp.New("failed to connect to any electrum server in the pool")
+}
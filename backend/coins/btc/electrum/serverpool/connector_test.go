@@ -0,0 +1,83 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverpool
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFailoverBoundedWhenAllServersUnreachable reproduces the scenario that used to cause
+// unbounded recursion: every known server is simultaneously unreachable. failover must give up
+// after trying each known server at most once, rather than cycling between them forever.
+func TestFailoverBoundedWhenAllServersUnreachable(t *testing.T) {
+	pool := NewPool(nil)
+	pool.AddPeers([]*client.ServerPeer{
+		{Hostname: "a.example.com", TCPPort: 1},
+		{Hostname: "b.example.com", TCPPort: 1},
+	})
+
+	attempts := 0
+	connector := NewConnector(pool, func(server Server) (*client.ElectrumClient, error) {
+		attempts++
+		return nil, errp.New("connection refused")
+	})
+
+	electrumClient, err := connector.Connect()
+	require.Error(t, err)
+	require.Nil(t, electrumClient)
+	require.LessOrEqual(t, attempts, pool.Len())
+}
+
+// TestFailoverTriesEveryServerExactlyOnce ensures a single unreachable server doesn't stop
+// failover from trying the next-best remaining one, and that no server is dialed more than once
+// within the same failover pass.
+func TestFailoverTriesEveryServerExactlyOnce(t *testing.T) {
+	pool := NewPool(nil)
+	pool.AddPeers([]*client.ServerPeer{
+		{Hostname: "a.example.com", TCPPort: 1},
+		{Hostname: "b.example.com", TCPPort: 1},
+		{Hostname: "c.example.com", TCPPort: 1},
+	})
+
+	attempts := map[Server]int{}
+	connector := NewConnector(pool, func(server Server) (*client.ElectrumClient, error) {
+		attempts[server]++
+		return nil, errp.New("connection refused")
+	})
+
+	_, err := connector.Connect()
+	require.Error(t, err)
+	require.Len(t, attempts, pool.Len(), "every known server should have been tried")
+	for server, count := range attempts {
+		require.Equal(t, 1, count, "server %v should only be dialed once per failover pass", server)
+	}
+}
+
+// TestFailoverReturnsErrorWhenPoolEmpty covers the case where the pool has no candidates at all.
+func TestFailoverReturnsErrorWhenPoolEmpty(t *testing.T) {
+	pool := NewPool(nil)
+	connector := NewConnector(pool, func(server Server) (*client.ElectrumClient, error) {
+		t.Fatal("dial should never be called against an empty pool")
+		return nil, nil
+	})
+
+	electrumClient, err := connector.Connect()
+	require.Error(t, err)
+	require.Nil(t, electrumClient)
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package serverpool maintains a scored pool of candidate Electrum servers, discovered via
+// client.ElectrumClient.ServerPeersSubscribe or pinned by the user, and picks a healthy one to
+// (re)connect to on demand.
+package serverpool
+
+import (
+	"sync"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox02-api-go/util/semver"
+)
+
+// maxConsecutiveInvalidProofs is how many SPV-invalid responses in a row a server may serve
+// before it is dropped from the pool entirely.
+const maxConsecutiveInvalidProofs = 1
+
+// maxConsecutiveDialFailures is how many times in a row a server may fail to connect before it is
+// dropped from the pool entirely.
+const maxConsecutiveDialFailures = 3
+
+// Server identifies a single candidate Electrum server to connect to.
+type Server struct {
+	Hostname string
+	Port     int
+	// TLS indicates whether Port expects a TLS connection.
+	TLS bool
+}
+
+// score tracks how well a Server has performed, used to rank candidates in Next().
+type score struct {
+	// latencyMilliseconds is the last observed connection/response latency. 0 means unknown.
+	latencyMilliseconds int64
+	// protocolVersion is the protocol version last advertised by this server, if known.
+	protocolVersion *semver.SemVer
+	// invalidProofs counts consecutive SPV-invalid responses served by this peer.
+	invalidProofs int
+	// dialFailures counts consecutive connection failures to this peer.
+	dialFailures int
+}
+
+// Pool maintains a scored set of candidate Electrum servers.
+type Pool struct {
+	// minimumProtocolVersion, if not nil, filters out discovered peers advertising a lower
+	// protocol version.
+	minimumProtocolVersion *semver.SemVer
+
+	mu     sync.Mutex
+	pinned []Server
+	scores map[Server]*score
+}
+
+// NewPool creates an empty pool.
+func NewPool(minimumProtocolVersion *semver.SemVer) *Pool {
+	return &Pool{
+		minimumProtocolVersion: minimumProtocolVersion,
+		scores:                 map[Server]*score{},
+	}
+}
+
+// SetPinnedServers restricts Next() to this user-preferred host list. Discovered peers are still
+// recorded and scored, but will not be suggested while a pinned list is set. Pass nil to clear it.
+func (pool *Pool) SetPinnedServers(servers []Server) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.pinned = servers
+}
+
+// AddPeers merges newly discovered peers, e.g. from ServerPeersSubscribe, into the pool.
+func (pool *Pool) AddPeers(peers []*client.ServerPeer) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, peer := range peers {
+		if pool.minimumProtocolVersion != nil &&
+			peer.ProtocolVersion != nil &&
+			!peer.ProtocolVersion.AtLeast(pool.minimumProtocolVersion) {
+			continue
+		}
+		for _, server := range serversFromPeer(peer) {
+			if _, ok := pool.scores[server]; !ok {
+				pool.scores[server] = &score{protocolVersion: peer.ProtocolVersion}
+			}
+		}
+	}
+}
+
+func serversFromPeer(peer *client.ServerPeer) []Server {
+	var servers []Server
+	if peer.SSLPort != 0 {
+		servers = append(servers, Server{Hostname: peer.Hostname, Port: peer.SSLPort, TLS: true})
+	}
+	if peer.TCPPort != 0 {
+		servers = append(servers, Server{Hostname: peer.Hostname, Port: peer.TCPPort, TLS: false})
+	}
+	return servers
+}
+
+func (pool *Pool) scoreFor(server Server) *score {
+	s, ok := pool.scores[server]
+	if !ok {
+		s = &score{}
+		pool.scores[server] = s
+	}
+	return s
+}
+
+// ReportLatency records an observed connection/response latency for server, lower being better.
+func (pool *Pool) ReportLatency(server Server, milliseconds int64) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.scoreFor(server).latencyMilliseconds = milliseconds
+}
+
+// ReportInvalidProof records that server served a response that failed SPV verification (e.g. a
+// bad merkle proof). A server that does this too many times in a row is dropped from the pool, on
+// the assumption that it is broken or malicious. A pinned server (see SetPinnedServers) is not
+// dropped: only its score is reset, and Next() still suggests it.
+func (pool *Pool) ReportInvalidProof(server Server) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	s := pool.scoreFor(server)
+	s.invalidProofs++
+	if s.invalidProofs > maxConsecutiveInvalidProofs {
+		delete(pool.scores, server)
+	}
+}
+
+// ReportValidProof resets server's invalid-proof counter after it has served a response that
+// passed SPV verification.
+func (pool *Pool) ReportValidProof(server Server) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if s, ok := pool.scores[server]; ok {
+		s.invalidProofs = 0
+	}
+}
+
+// ReportDialFailure records that connecting to server failed. A server that fails to connect too
+// many times in a row is dropped from the pool entirely. A pinned server (see SetPinnedServers) is
+// not dropped: only its score is reset, and Next() still suggests it.
+func (pool *Pool) ReportDialFailure(server Server) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	s := pool.scoreFor(server)
+	s.dialFailures++
+	if s.dialFailures > maxConsecutiveDialFailures {
+		delete(pool.scores, server)
+	}
+}
+
+// ReportDialSuccess resets server's dial-failure counter after a successful connection.
+func (pool *Pool) ReportDialSuccess(server Server) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if s, ok := pool.scores[server]; ok {
+		s.dialFailures = 0
+	}
+}
+
+// Next picks the best candidate server to (re)connect to, excluding any server in exclude
+// (typically servers that were just disconnected, or already failed during the current failover
+// attempt). It returns false if no candidate is left.
+func (pool *Pool) Next(exclude map[Server]bool) (Server, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	candidates := pool.pinned
+	if len(candidates) == 0 {
+		for server := range pool.scores {
+			candidates = append(candidates, server)
+		}
+	}
+	var best *Server
+	var bestScore *score
+	for i := range candidates {
+		server := candidates[i]
+		if exclude[server] {
+			continue
+		}
+		s := pool.scores[server]
+		if s == nil {
+			s = &score{}
+		}
+		if best == nil || better(s, bestScore) {
+			server := server
+			best = &server
+			bestScore = s
+		}
+	}
+	if best == nil {
+		return Server{}, false
+	}
+	return *best, true
+}
+
+// Len returns the number of servers currently known to the pool (pinned or discovered).
+func (pool *Pool) Len() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.pinned) > 0 {
+		return len(pool.pinned)
+	}
+	return len(pool.scores)
+}
+
+// better reports whether a is a better candidate than b: fewer consecutive dial failures first,
+// then fewer consecutive invalid proofs, then lower latency. Unknown (zero) latency is treated as
+// worse than any measured latency.
+func better(a, b *score) bool {
+	if a.dialFailures != b.dialFailures {
+		return a.dialFailures < b.dialFailures
+	}
+	if a.invalidProofs != b.invalidProofs {
+		return a.invalidProofs < b.invalidProofs
+	}
+	if a.latencyMilliseconds == 0 {
+		return false
+	}
+	if b.latencyMilliseconds == 0 {
+		return true
+	}
+	return a.latencyMilliseconds < b.latencyMilliseconds
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverpool
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox02-api-go/util/semver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBetter(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *score
+		want bool
+	}{
+		{"fewer dial failures wins", &score{dialFailures: 0}, &score{dialFailures: 1}, true},
+		{"more dial failures loses", &score{dialFailures: 1}, &score{dialFailures: 0}, false},
+		{
+			"dial failures take priority over invalid proofs",
+			&score{dialFailures: 0, invalidProofs: 5},
+			&score{dialFailures: 1, invalidProofs: 0},
+			true,
+		},
+		{
+			"fewer invalid proofs wins when dial failures tie",
+			&score{invalidProofs: 0},
+			&score{invalidProofs: 1},
+			true,
+		},
+		{
+			"lower latency wins when failures/proofs tie",
+			&score{latencyMilliseconds: 10},
+			&score{latencyMilliseconds: 20},
+			true,
+		},
+		{
+			"unknown latency (0) is worse than a known one",
+			&score{latencyMilliseconds: 0},
+			&score{latencyMilliseconds: 20},
+			false,
+		},
+		{
+			"known latency is better than unknown (0)",
+			&score{latencyMilliseconds: 20},
+			&score{latencyMilliseconds: 0},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.want, better(test.a, test.b))
+		})
+	}
+}
+
+func TestPoolNextExcludesGivenServers(t *testing.T) {
+	pool := NewPool(nil)
+	pool.AddPeers([]*client.ServerPeer{
+		{Hostname: "a.example.com", TCPPort: 1},
+		{Hostname: "b.example.com", TCPPort: 1},
+	})
+	serverA := Server{Hostname: "a.example.com", Port: 1}
+	serverB := Server{Hostname: "b.example.com", Port: 1}
+
+	next, ok := pool.Next(nil)
+	require.True(t, ok)
+	require.Contains(t, []Server{serverA, serverB}, next)
+
+	next, ok = pool.Next(map[Server]bool{serverA: true, serverB: true})
+	require.False(t, ok)
+	require.Zero(t, next)
+}
+
+func TestPoolNextPrefersFewerDialFailures(t *testing.T) {
+	pool := NewPool(nil)
+	pool.AddPeers([]*client.ServerPeer{
+		{Hostname: "a.example.com", TCPPort: 1},
+		{Hostname: "b.example.com", TCPPort: 1},
+	})
+	serverA := Server{Hostname: "a.example.com", Port: 1}
+	serverB := Server{Hostname: "b.example.com", Port: 1}
+
+	pool.ReportDialFailure(serverA)
+	next, ok := pool.Next(nil)
+	require.True(t, ok)
+	require.Equal(t, serverB, next)
+}
+
+func TestPoolDropsServerAfterTooManyDialFailures(t *testing.T) {
+	pool := NewPool(nil)
+	pool.AddPeers([]*client.ServerPeer{{Hostname: "a.example.com", TCPPort: 1}})
+	serverA := Server{Hostname: "a.example.com", Port: 1}
+
+	for i := 0; i < maxConsecutiveDialFailures+1; i++ {
+		pool.ReportDialFailure(serverA)
+	}
+	_, ok := pool.Next(nil)
+	require.False(t, ok)
+}
+
+func TestAddPeersFiltersByMinimumProtocolVersion(t *testing.T) {
+	minVersion := semver.NewSemVer(1, 4, 0)
+	pool := NewPool(minVersion)
+	pool.AddPeers([]*client.ServerPeer{
+		{Hostname: "old.example.com", TCPPort: 1, ProtocolVersion: semver.NewSemVer(1, 2, 0)},
+		{Hostname: "new.example.com", TCPPort: 1, ProtocolVersion: semver.NewSemVer(1, 4, 0)},
+	})
+
+	next, ok := pool.Next(nil)
+	require.True(t, ok)
+	require.Equal(t, Server{Hostname: "new.example.com", Port: 1}, next)
+}